@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// acmeDNSSolverConfig is decoded from the per-issuer Config on the
+// ChallengeRequest. AccountSecretName points at the Kubernetes Secret used
+// to persist acme-dns credentials, one entry per challenge name, keyed by
+// ChallengeRequest.ResolvedFQDN. A domain's apex and wildcard challenges
+// (DNSName "example.com" and "*.example.com") share the same ResolvedFQDN
+// ("_acme-challenge.example.com") and therefore the same account: acme-dns
+// is only ever pointed at by a single CNAME, so both must resolve to the
+// same subdomain, and acme-dns retains the last two TXT values it was given
+// for exactly this reason.
+type acmeDNSSolverConfig struct {
+	Host              string `json:"host"`
+	AccountSecretName string `json:"accountSecretName"`
+}
+
+// acmeDNSAccount is the per-domain credential set returned by an acme-dns
+// /register call and thereafter stored in the account Secret.
+type acmeDNSAccount struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Subdomain  string `json:"subdomain"`
+	FullDomain string `json:"fulldomain"`
+}
+
+// acmeDNSSolver delegates DNS-01 challenges to an acme-dns server. Operators
+// create a one-time CNAME from _acme-challenge.example.com to
+// <subdomain>.auth.acme-dns.io, which lets them keep their real DNS zone
+// read-only while the webhook updates the TXT value directly on acme-dns.
+type acmeDNSSolver struct {
+	kubeClient kubernetes.Interface
+}
+
+func (s *acmeDNSSolver) Name() string {
+	return "acme-dns-01-solver"
+}
+
+func (s *acmeDNSSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return fmt.Errorf("acme-dns: error building kubernetes client: %v", err)
+	}
+	s.kubeClient = cl
+	return nil
+}
+
+func (s *acmeDNSSolver) Present(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadAcmeDNSConfig(ch)
+	if err != nil {
+		return err
+	}
+
+	account, err := s.getOrRegisterAccount(ch, cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := s.update(cfg, account, ch.Key); err != nil {
+		return fmt.Errorf("acme-dns: error presenting TXT record for %s: %v", ch.ResolvedFQDN, err)
+	}
+
+	fmt.Printf("acme-dns: TXT record presented for %v\n", ch.ResolvedFQDN)
+	return nil
+}
+
+// CleanUp is a no-op: acme-dns's /update endpoint requires a valid 43-char
+// base64url TXT value and rejects an empty one, and it has no delete
+// endpoint, so there is nothing we can usefully push here. The record is
+// harmless between issuances since it is only ever looked up via the
+// challenge CNAME, and is simply overwritten on the next Present.
+func (s *acmeDNSSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	return nil
+}
+
+func loadAcmeDNSConfig(ch *v1alpha1.ChallengeRequest) (*acmeDNSSolverConfig, error) {
+	if ch.Config == nil || len(ch.Config.Raw) == 0 {
+		return nil, fmt.Errorf("acme-dns: no solver config present on ChallengeRequest")
+	}
+
+	var cfg acmeDNSSolverConfig
+	if err := json.Unmarshal(ch.Config.Raw, &cfg); err != nil {
+		return nil, fmt.Errorf("acme-dns: error decoding solver config: %v", err)
+	}
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("acme-dns: host must be set in the issuer config")
+	}
+	if cfg.AccountSecretName == "" {
+		return nil, fmt.Errorf("acme-dns: accountSecretName must be set in the issuer config")
+	}
+	return &cfg, nil
+}
+
+// secretDataKey maps a challenge's ResolvedFQDN to a valid Kubernetes
+// Secret data key. Secret data keys must match [-._a-zA-Z0-9]+, which the
+// leading "*" a wildcard DNSName would carry violates; ResolvedFQDN never
+// has one (it's always the literal "_acme-challenge.<domain>" name), but
+// the replacement is kept as a defensive no-op for any future caller that
+// passes a raw DNSName instead.
+func secretDataKey(resolvedFQDN string) string {
+	return strings.Replace(resolvedFQDN, "*", "_wildcard_", 1)
+}
+
+func (s *acmeDNSSolver) getAccount(ch *v1alpha1.ChallengeRequest, cfg *acmeDNSSolverConfig) (*acmeDNSAccount, error) {
+	secret, err := s.kubeClient.CoreV1().Secrets(ch.ResourceNamespace).Get(context.TODO(), cfg.AccountSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := secret.Data[secretDataKey(ch.ResolvedFQDN)]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), cfg.AccountSecretName)
+	}
+
+	var account acmeDNSAccount
+	if err := json.Unmarshal(raw, &account); err != nil {
+		return nil, fmt.Errorf("acme-dns: error decoding stored account for %s: %v", ch.ResolvedFQDN, err)
+	}
+	return &account, nil
+}
+
+// secretLockFor returns a process-wide mutex for the given Secret, creating
+// one on first use. getOrRegisterAccount holds it across its register+save
+// so two concurrent Presents that both miss the cached account (e.g. the
+// apex and wildcard challenges of one certificate, before either has saved
+// anything yet) don't independently Get->register->Create/Update the same
+// Secret, which would otherwise race -- failing one side with a
+// resourceVersion conflict or AlreadyExists -- and leave a duplicate,
+// orphaned acme-dns account registered.
+var secretLocks sync.Map // "namespace/name" -> *sync.Mutex
+
+func secretLockFor(namespace, name string) *sync.Mutex {
+	lock, _ := secretLocks.LoadOrStore(namespace+"/"+name, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func (s *acmeDNSSolver) getOrRegisterAccount(ch *v1alpha1.ChallengeRequest, cfg *acmeDNSSolverConfig) (*acmeDNSAccount, error) {
+	account, err := s.getAccount(ch, cfg)
+	if err == nil {
+		return account, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	lock := secretLockFor(ch.ResourceNamespace, cfg.AccountSecretName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Re-check now that we hold the lock: another goroutine may have
+	// already registered and saved the account for this ResolvedFQDN while
+	// we were waiting for it.
+	account, err = s.getAccount(ch, cfg)
+	if err == nil {
+		return account, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	account, err = registerAcmeDNSAccount(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.saveAccount(ch, cfg, account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func registerAcmeDNSAccount(cfg *acmeDNSSolverConfig) (*acmeDNSAccount, error) {
+	resp, err := http.Post(fmt.Sprintf("%s/register", cfg.Host), "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("acme-dns: error registering account: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("acme-dns: registration failed: received status code %v", resp.StatusCode)
+	}
+
+	var account acmeDNSAccount
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return nil, fmt.Errorf("acme-dns: error decoding registration response: %v", err)
+	}
+	return &account, nil
+}
+
+func (s *acmeDNSSolver) saveAccount(ch *v1alpha1.ChallengeRequest, cfg *acmeDNSSolverConfig, account *acmeDNSAccount) error {
+	raw, err := json.Marshal(account)
+	if err != nil {
+		return fmt.Errorf("acme-dns: error encoding account for %s: %v", ch.ResolvedFQDN, err)
+	}
+
+	secrets := s.kubeClient.CoreV1().Secrets(ch.ResourceNamespace)
+
+	secret, err := secrets.Get(context.TODO(), cfg.AccountSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cfg.AccountSecretName,
+				Namespace: ch.ResourceNamespace,
+			},
+			Data: map[string][]byte{secretDataKey(ch.ResolvedFQDN): raw},
+		}
+		_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("acme-dns: error fetching account secret %s/%s: %v", ch.ResourceNamespace, cfg.AccountSecretName, err)
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[secretDataKey(ch.ResolvedFQDN)] = raw
+	_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *acmeDNSSolver) update(cfg *acmeDNSSolverConfig, account *acmeDNSAccount, txt string) error {
+	reqBody, err := json.Marshal(map[string]string{
+		"subdomain": account.Subdomain,
+		"txt":       txt,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding update request: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/update", cfg.Host), bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("error creating update request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-User", account.Username)
+	req.Header.Set("X-Api-Key", account.Password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making update request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("update failed: received status code %v", resp.StatusCode)
+	}
+
+	return nil
+}
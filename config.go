@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// secretKeyRef points at a single key within a Kubernetes Secret. If
+// Namespace is empty, the Secret is looked up in the Issuer/ClusterIssuer's
+// resource namespace (ChallengeRequest.ResourceNamespace).
+type secretKeyRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+}
+
+// cubeCloudDNSSolverConfig is decoded from the per-issuer Config on the
+// ChallengeRequest, so a single webhook deployment can serve many
+// Issuer/ClusterIssuer resources pointed at different Cloud Router tenants.
+type cubeCloudDNSSolverConfig struct {
+	APIDomain                 string       `json:"apiDomain"`
+	APITokenSecretRef         secretKeyRef `json:"apiTokenSecretRef"`
+	CloudRouterPath           string       `json:"cloudRouterPath,omitempty"`
+	PropagationTimeoutSeconds int          `json:"propagationTimeout,omitempty"`
+	PollIntervalSeconds       int          `json:"pollInterval,omitempty"`
+	DisablePropagationCheck   bool         `json:"disablePropagationCheck,omitempty"`
+	RecursiveNameservers      []string     `json:"recursiveNameservers,omitempty"`
+}
+
+// loadConfig decodes and validates the per-issuer Config carried on ch.
+func (c *cubeCloudDNSSolver) loadConfig(ch *v1alpha1.ChallengeRequest) (*cubeCloudDNSSolverConfig, error) {
+	if ch.Config == nil || len(ch.Config.Raw) == 0 {
+		return nil, fmt.Errorf("no solver config present on ChallengeRequest")
+	}
+
+	var cfg cubeCloudDNSSolverConfig
+	if err := json.Unmarshal(ch.Config.Raw, &cfg); err != nil {
+		return nil, fmt.Errorf("error decoding solver config: %v", err)
+	}
+
+	if cfg.APIDomain == "" {
+		return nil, fmt.Errorf("apiDomain must be set in the issuer config")
+	}
+	if cfg.APITokenSecretRef.Name == "" || cfg.APITokenSecretRef.Key == "" {
+		return nil, fmt.Errorf("apiTokenSecretRef.name and apiTokenSecretRef.key must be set in the issuer config")
+	}
+	if cfg.CloudRouterPath == "" {
+		cfg.CloudRouterPath = "/_cloud-router/dns-challenge"
+	}
+
+	return &cfg, nil
+}
+
+// apiToken resolves the Cloud Router API token referenced by
+// cfg.APITokenSecretRef, defaulting to ch.ResourceNamespace when the ref
+// does not specify one.
+func (c *cubeCloudDNSSolver) apiToken(ch *v1alpha1.ChallengeRequest, cfg *cubeCloudDNSSolverConfig) (string, error) {
+	namespace := cfg.APITokenSecretRef.Namespace
+	if namespace == "" {
+		namespace = ch.ResourceNamespace
+	}
+
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), cfg.APITokenSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error fetching apiTokenSecretRef %s/%s: %v", namespace, cfg.APITokenSecretRef.Name, err)
+	}
+
+	token, ok := secret.Data[cfg.APITokenSecretRef.Key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret %s/%s", cfg.APITokenSecretRef.Key, namespace, cfg.APITokenSecretRef.Name)
+	}
+
+	return string(token), nil
+}
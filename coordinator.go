@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// fqdnBatchWindow is how long the coordinator waits for additional
+// concurrent Present calls for the same FQDN to join before issuing the
+// upstream Cloud Router request with the union of their keys. This is what
+// lets a certificate requesting both example.com and *.example.com coalesce
+// into a single request instead of racing two independent ones.
+const fqdnBatchWindow = 200 * time.Millisecond
+
+// fqdnCoordinator de-duplicates and batches concurrent ACME challenges that
+// resolve to the same FQDN so they don't race or clobber each other's TXT
+// records at the Cloud Router.
+type fqdnCoordinator struct {
+	mu      sync.Mutex
+	entries map[string]*fqdnEntry
+}
+
+// fqdnEntry tracks the outstanding challenge keys for a single FQDN, plus
+// the batch currently being collected, if any.
+type fqdnEntry struct {
+	mu      sync.Mutex
+	keys    map[string]struct{}
+	pending *fqdnBatch
+
+	// opMu serializes the actual upstream publish/remove calls for this
+	// FQDN, so a CleanUp's delete can never land in between a concurrent
+	// Present's bookkeeping and its publish -- see cleanUp.
+	opMu sync.Mutex
+}
+
+// fqdnBatch is an in-flight, coalesced upstream Present call: any Present
+// that joins it waits on done and shares its result instead of issuing its
+// own request.
+type fqdnBatch struct {
+	done chan struct{}
+	err  error
+}
+
+func newFqdnCoordinator() *fqdnCoordinator {
+	return &fqdnCoordinator{entries: make(map[string]*fqdnEntry)}
+}
+
+func (c *fqdnCoordinator) entryFor(fqdn string) *fqdnEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[fqdn]
+	if !ok {
+		e = &fqdnEntry{keys: make(map[string]struct{})}
+		c.entries[fqdn] = e
+	}
+	return e
+}
+
+// present registers key as outstanding for fqdn, then, after fqdnBatchWindow,
+// publishes the union of all keys outstanding for fqdn in a single call to
+// publish, shared by every Present that joined within the window.
+// Callers only ever contend for the brief bookkeeping below while collecting
+// the batch; opMu is only held for the upstream call itself, and only to
+// stay mutually exclusive with a concurrent cleanUp's delete (see cleanUp).
+func (c *fqdnCoordinator) present(fqdn, key string, publish func(keys []string) error) error {
+	e := c.entryFor(fqdn)
+
+	e.mu.Lock()
+	e.keys[key] = struct{}{}
+
+	if e.pending != nil {
+		batch := e.pending
+		e.mu.Unlock()
+		<-batch.done
+		return batch.err
+	}
+
+	batch := &fqdnBatch{done: make(chan struct{})}
+	e.pending = batch
+	e.mu.Unlock()
+
+	time.Sleep(fqdnBatchWindow)
+
+	e.mu.Lock()
+	keys := e.sortedKeysLocked()
+	e.pending = nil
+	e.mu.Unlock()
+
+	e.opMu.Lock()
+	batch.err = publish(keys)
+	e.opMu.Unlock()
+
+	close(batch.done)
+	return batch.err
+}
+
+// cleanUp removes key from the outstanding set for fqdn. remove (the actual
+// upstream delete) is only invoked once the last outstanding challenge for
+// fqdn has been cleaned up, since earlier callers' records are still needed
+// until then.
+//
+// Deciding "last" and calling remove must be atomic with respect to a new
+// present() joining in between: without opMu, a present() could publish its
+// key to the Cloud Router concurrently with our delete, and our delete could
+// land after it, clobbering the record present() just wrote. opMu makes the
+// two calls mutually exclusive, and the recheck below after acquiring it
+// catches the case where such a present() already added a key (and is
+// queued behind opMu to publish it, or already did) before we got there --
+// in both cases we must leave the record alone instead of deleting it.
+func (c *fqdnCoordinator) cleanUp(fqdn, key string, remove func() error) error {
+	e := c.entryFor(fqdn)
+
+	e.mu.Lock()
+	delete(e.keys, key)
+	last := len(e.keys) == 0
+	e.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	e.opMu.Lock()
+	defer e.opMu.Unlock()
+
+	e.mu.Lock()
+	stillLast := len(e.keys) == 0
+	e.mu.Unlock()
+	if !stillLast {
+		return nil
+	}
+
+	if err := remove(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if len(e.keys) == 0 {
+		delete(c.entries, fqdn)
+	}
+	c.mu.Unlock()
+
+	return nil
+}
+
+// sortedKeysLocked returns e.keys as a sorted slice. Callers must hold e.mu.
+func (e *fqdnEntry) sortedKeysLocked() []string {
+	keys := make([]string, 0, len(e.keys))
+	for k := range e.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// globalFqdnCoordinator is process-wide: it only needs to coordinate
+// concurrent challenges handled by this webhook instance.
+var globalFqdnCoordinator = newFqdnCoordinator()
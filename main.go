@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"time"
 
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
@@ -17,6 +18,8 @@ import (
 func main() {
 	cmd.RunWebhookServer("cubecloud",
 		&cubeCloudDNSSolver{},
+		&rfc2136Solver{},
+		&acmeDNSSolver{},
 	)
 }
 
@@ -24,9 +27,12 @@ func main() {
 // 'present' an ACME challenge TXT record for your own DNS provider.
 // To do so, it must implement the `github.com/cert-manager/cert-manager/pkg/acme/webhook.Solver`
 // interface.
+// Unlike earlier versions, it carries no process-wide Cloud Router
+// credentials: every ChallengeRequest supplies its own tenant config and
+// token via Config/ResourceNamespace, so a single webhook deployment can
+// serve many Issuer/ClusterIssuer resources.
 type cubeCloudDNSSolver struct {
-	cloudRouterApiDomain string
-	cloudRouterApiToken  string
+	kubeClient kubernetes.Interface
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -39,12 +45,17 @@ func (c *cubeCloudDNSSolver) Name() string {
 	return "cube-cloud-dns-01-solver"
 }
 
-func (c *cubeCloudDNSSolver) MakeCloudRouterRequest(ch *v1alpha1.ChallengeRequest, action string) error {
-	apiEndpoint := fmt.Sprintf("https://%s/_cloud-router/dns-challenge/%s", c.cloudRouterApiDomain, action)
-	requestBody := map[string]string{
+// MakeCloudRouterRequest publishes or removes the TXT records for keys at
+// ch.ResolvedFQDN. For a "present" action, keys is the union of every
+// outstanding challenge key for that FQDN, coalesced by fqdnCoordinator; for
+// a "cleanup" action it is always empty, since CleanUp only reaches the
+// Cloud Router once every outstanding challenge for the FQDN is done.
+func (c *cubeCloudDNSSolver) MakeCloudRouterRequest(ch *v1alpha1.ChallengeRequest, cfg *cubeCloudDNSSolverConfig, token, action string, keys []string) error {
+	apiEndpoint := fmt.Sprintf("https://%s%s/%s", cfg.APIDomain, cfg.CloudRouterPath, action)
+	requestBody := map[string]interface{}{
 		"uid":          string(ch.UID),
 		"action":       action,
-		"key":          ch.Key,
+		"keys":         keys,
 		"resolvedFQDN": ch.ResolvedFQDN,
 		"dnsName":      ch.DNSName,
 	}
@@ -60,7 +71,7 @@ func (c *cubeCloudDNSSolver) MakeCloudRouterRequest(ch *v1alpha1.ChallengeReques
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.cloudRouterApiToken))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -82,9 +93,44 @@ func (c *cubeCloudDNSSolver) MakeCloudRouterRequest(ch *v1alpha1.ChallengeReques
 // DNS provider.
 // This method should tolerate being called multiple times with the same value.
 // cert-manager itself will later perform a self check to ensure that the
-// solver has correctly configured the DNS provider.
+// solver has correctly configured the DNS provider, but unless
+// disablePropagationCheck is set, Present actively waits for the record to
+// be visible on every authoritative nameserver first, so that self check
+// doesn't spin unnecessarily.
+// Concurrent Present calls for the same ResolvedFQDN (e.g. example.com and
+// *.example.com on one certificate) are coalesced by globalFqdnCoordinator
+// into a single upstream request so they don't race or clobber each other's
+// TXT records.
 func (c *cubeCloudDNSSolver) Present(ch *v1alpha1.ChallengeRequest) error {
-	return c.MakeCloudRouterRequest(ch, "present")
+	cfg, err := c.loadConfig(ch)
+	if err != nil {
+		return err
+	}
+	token, err := c.apiToken(ch, cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := globalFqdnCoordinator.present(ch.ResolvedFQDN, ch.Key, func(keys []string) error {
+		return c.MakeCloudRouterRequest(ch, cfg, token, "present", keys)
+	}); err != nil {
+		return err
+	}
+
+	if cfg.DisablePropagationCheck {
+		return nil
+	}
+
+	timeout := defaultPropagationTimeout
+	if cfg.PropagationTimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.PropagationTimeoutSeconds) * time.Second
+	}
+	pollInterval := defaultPollInterval
+	if cfg.PollIntervalSeconds > 0 {
+		pollInterval = time.Duration(cfg.PollIntervalSeconds) * time.Second
+	}
+
+	return checkDNSPropagation(ch.ResolvedFQDN, ch.Key, cfg.RecursiveNameservers, timeout, pollInterval)
 }
 
 // CleanUp should delete the relevant TXT record from the DNS provider console.
@@ -92,28 +138,37 @@ func (c *cubeCloudDNSSolver) Present(ch *v1alpha1.ChallengeRequest) error {
 // _acme-challenge.example.com) then **only** the record with the same `key`
 // value provided on the ChallengeRequest should be cleaned up.
 // This is in order to facilitate multiple DNS validations for the same domain
-// concurrently.
+// concurrently. globalFqdnCoordinator reference-counts outstanding
+// challenges per ResolvedFQDN, so the upstream delete is only issued once
+// the last challenge for that FQDN has been cleaned up.
 func (c *cubeCloudDNSSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
-	return c.MakeCloudRouterRequest(ch, "cleanup")
+	cfg, err := c.loadConfig(ch)
+	if err != nil {
+		return err
+	}
+	token, err := c.apiToken(ch, cfg)
+	if err != nil {
+		return err
+	}
+
+	return globalFqdnCoordinator.cleanUp(ch.ResolvedFQDN, ch.Key, func() error {
+		return c.MakeCloudRouterRequest(ch, cfg, token, "cleanup", nil)
+	})
 }
 
 // Initialize will be called when the webhook first starts.
 // This method can be used to instantiate the webhook, i.e. initialising
 // connections or warming up caches.
-// Typically, the kubeClientConfig parameter is used to build a Kubernetes
-// client that can be used to fetch resources from the Kubernetes API, e.g.
-// Secret resources containing credentials used to authenticate with DNS
-// provider accounts.
+// kubeClientConfig is used to build a Kubernetes client so Present/CleanUp
+// can fetch the per-tenant Cloud Router API token from the Secret
+// referenced on each ChallengeRequest's Config.
 // The stopCh can be used to handle early termination of the webhook, in cases
 // where a SIGTERM or similar signal is sent to the webhook process.
 func (c *cubeCloudDNSSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
-	c.cloudRouterApiDomain = os.Getenv("CLOUD_ROUTER_API_DOMAIN")
-	if c.cloudRouterApiDomain == "" {
-		return fmt.Errorf("CLOUD_ROUTER_API_DOMAIN must be set")
-	}
-	c.cloudRouterApiToken = os.Getenv("CLOUD_ROUTER_API_TOKEN")
-	if c.cloudRouterApiToken == "" {
-		return fmt.Errorf("CLOUD_ROUTER_API_TOKEN must be set")
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes client: %v", err)
 	}
+	c.kubeClient = cl
 	return nil
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultPropagationTimeout and defaultPollInterval bound how long Present
+// waits for a TXT record to become visible on every authoritative
+// nameserver before giving up, when the issuer config does not override
+// them.
+const (
+	defaultPropagationTimeout = 2 * time.Minute
+	defaultPollInterval       = 2 * time.Second
+	maxPollInterval           = 16 * time.Second
+)
+
+// zoneNameserverCache remembers the authoritative nameserver hostnames
+// already resolved for a zone, so repeated challenges against the same
+// domain don't each have to resolve NS records again. The SOA walk that
+// finds the zone itself is not cached and runs for every challenge.
+var zoneNameserverCache sync.Map // zone (string) -> []string (NS hostnames)
+
+// checkDNSPropagation polls every authoritative nameserver for fqdn until
+// each one returns a TXT record containing value, or timeout elapses. It
+// returns a descriptive error listing the nameservers that were still
+// missing the record on timeout.
+func checkDNSPropagation(fqdn, value string, recursiveNameservers []string, timeout, pollInterval time.Duration) error {
+	nameservers, err := authoritativeNameservers(fqdn, recursiveNameservers)
+	if err != nil {
+		return fmt.Errorf("error resolving authoritative nameservers for %s: %v", fqdn, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	backoff := pollInterval
+
+	for {
+		missing := missingNameservers(fqdn, value, nameservers)
+		if len(missing) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s TXT record to propagate, still missing on: %s", fqdn, strings.Join(missing, ", "))
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxPollInterval {
+			backoff = maxPollInterval
+		}
+	}
+}
+
+// missingNameservers returns the subset of nameservers that do not yet
+// serve a TXT record equal to value at fqdn.
+func missingNameservers(fqdn, value string, nameservers []string) []string {
+	var missing []string
+	for _, ns := range nameservers {
+		ok, err := nameserverHasTXT(ns, fqdn, value)
+		if err != nil || !ok {
+			missing = append(missing, ns)
+		}
+	}
+	return missing
+}
+
+// nameserverHasTXT resolves nameserver's own addresses and queries each one
+// in turn, treating the nameserver as satisfied as soon as any address
+// answers with the expected TXT value. An address family that is simply
+// unreachable from here (e.g. an AAAA record on an IPv4-only cluster) is
+// skipped rather than counted as "missing": an error is only returned when
+// none of the nameserver's addresses could be reached at all.
+func nameserverHasTXT(nameserver, fqdn, value string) (bool, error) {
+	addrs, err := net.LookupHost(nameserver)
+	if err != nil {
+		return false, err
+	}
+
+	reachable := false
+	for _, addr := range addrs {
+		ok, err := queryTXT(net.JoinHostPort(addr, "53"), fqdn, value)
+		if err != nil {
+			continue
+		}
+		reachable = true
+		if ok {
+			return true, nil
+		}
+	}
+
+	if !reachable {
+		return false, fmt.Errorf("no reachable address for %s", nameserver)
+	}
+	return false, nil
+}
+
+// queryTXT asks addr directly for the TXT record at fqdn and reports
+// whether any answer equals value.
+func queryTXT(addr, fqdn, value string) (bool, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+	m.RecursionDesired = false
+
+	c := &dns.Client{Timeout: 10 * time.Second}
+
+	in, _, err := c.Exchange(m, addr)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rr := range in.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		for _, s := range txt.Txt {
+			if s == value {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// authoritativeNameservers walks up fqdn to find its zone's SOA record, then
+// returns the hostnames of that zone's NS records, consulting
+// zoneNameserverCache first. Address resolution is deferred to
+// nameserverHasTXT, since which of a hostname's addresses are reachable can
+// only be judged at query time.
+func authoritativeNameservers(fqdn string, recursiveNameservers []string) ([]string, error) {
+	zone, err := findZoneBySOA(fqdn, recursiveNameservers)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := zoneNameserverCache.Load(zone); ok {
+		return cached.([]string), nil
+	}
+
+	resolver := pickResolver(recursiveNameservers)
+
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeNS)
+
+	c := &dns.Client{Timeout: 10 * time.Second}
+	in, _, err := c.Exchange(m, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	var nameservers []string
+	for _, rr := range in.Answer {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		nameservers = append(nameservers, strings.TrimSuffix(ns.Ns, "."))
+	}
+
+	if len(nameservers) == 0 {
+		return nil, fmt.Errorf("no authoritative nameservers found for zone %s", zone)
+	}
+
+	zoneNameserverCache.Store(zone, nameservers)
+	return nameservers, nil
+}
+
+// findZoneBySOA walks up fqdn one label at a time until a resolver returns
+// an SOA record, which identifies the zone apex.
+func findZoneBySOA(fqdn string, recursiveNameservers []string) (string, error) {
+	resolver := pickResolver(recursiveNameservers)
+	c := &dns.Client{Timeout: 10 * time.Second}
+
+	labels := dns.SplitDomainName(fqdn)
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		m := new(dns.Msg)
+		m.SetQuestion(candidate, dns.TypeSOA)
+
+		in, _, err := c.Exchange(m, resolver)
+		if err != nil {
+			continue
+		}
+		for _, rr := range in.Answer {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return soa.Hdr.Name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("could not find SOA record for %s", fqdn)
+}
+
+// pickResolver returns the first configured recursive nameserver, or a
+// public fallback resolver if none were configured.
+func pickResolver(recursiveNameservers []string) string {
+	if len(recursiveNameservers) > 0 {
+		ns := recursiveNameservers[0]
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(ns, "53")
+		}
+		return ns
+	}
+	return "8.8.8.8:53"
+}
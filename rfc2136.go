@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/client-go/rest"
+)
+
+// rfc2136TTL is the TTL, in seconds, used for the TXT record published via
+// dynamic update. It is kept short since the record only needs to live long
+// enough for the ACME server to complete its DNS-01 self-check.
+const rfc2136TTL = 60
+
+// rfc2136Config holds everything needed to publish a dynamic DNS update to
+// an authoritative nameserver via RFC 2136. It can be set globally through
+// environment variables, or overridden per-Issuer via the webhook's
+// ChallengeRequest.Config.
+type rfc2136Config struct {
+	Nameserver     string `json:"nameserver"`
+	Zone           string `json:"zone"`
+	TSIGKeyName    string `json:"tsigKeyName"`
+	TSIGAlgorithm  string `json:"tsigAlgorithm"`
+	TSIGSecret     string `json:"tsigSecret"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+// rfc2136Solver publishes DNS-01 challenges straight to an authoritative
+// nameserver via RFC 2136 dynamic updates. It is registered as a sibling to
+// cubeCloudDNSSolver so operators have a fallback that doesn't depend on the
+// Cloud Router API being reachable.
+type rfc2136Solver struct{}
+
+func (s *rfc2136Solver) Name() string {
+	return "rfc2136-dns-01-solver"
+}
+
+// Initialize is a no-op: RFC 2136 updates are authenticated with a TSIG key,
+// not a Kubernetes client.
+func (s *rfc2136Solver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	return nil
+}
+
+func (s *rfc2136Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadRFC2136Config(ch)
+	if err != nil {
+		return err
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN TXT %q", ch.ResolvedFQDN, rfc2136TTL, ch.Key))
+	if err != nil {
+		return fmt.Errorf("rfc2136: error building TXT record for %s: %v", ch.ResolvedFQDN, err)
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(cfg.Zone))
+	m.Insert([]dns.RR{rr})
+
+	if err := sendRFC2136Update(cfg, m); err != nil {
+		return fmt.Errorf("rfc2136: error presenting TXT record for %s: %v", ch.ResolvedFQDN, err)
+	}
+
+	fmt.Printf("rfc2136: TXT record presented for %v\n", ch.ResolvedFQDN)
+	return nil
+}
+
+// CleanUp removes only the TXT record matching ch.Key, by deleting that
+// single RR from its RRset, so other DNS-01 challenges for the same name are
+// left untouched.
+func (s *rfc2136Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadRFC2136Config(ch)
+	if err != nil {
+		return err
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN TXT %q", ch.ResolvedFQDN, rfc2136TTL, ch.Key))
+	if err != nil {
+		return fmt.Errorf("rfc2136: error building TXT record for %s: %v", ch.ResolvedFQDN, err)
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(cfg.Zone))
+	m.Remove([]dns.RR{rr})
+
+	if err := sendRFC2136Update(cfg, m); err != nil {
+		return fmt.Errorf("rfc2136: error cleaning up TXT record for %s: %v", ch.ResolvedFQDN, err)
+	}
+
+	fmt.Printf("rfc2136: TXT record removed for %v\n", ch.ResolvedFQDN)
+	return nil
+}
+
+func sendRFC2136Update(cfg *rfc2136Config, m *dns.Msg) error {
+	c := new(dns.Client)
+	c.Timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+
+	if cfg.TSIGKeyName != "" {
+		// miekg/dns expects the algorithm in fqdn form (e.g. "hmac-sha256.");
+		// normalize it the same way we already do for the key name below, so
+		// a config value of "hmac-sha256" (no trailing dot) doesn't silently
+		// fail to sign.
+		algo := dns.HmacSHA256
+		if cfg.TSIGAlgorithm != "" {
+			algo = dns.Fqdn(cfg.TSIGAlgorithm)
+		}
+		keyName := dns.Fqdn(cfg.TSIGKeyName)
+		m.SetTsig(keyName, algo, 300, time.Now().Unix())
+		c.TsigSecret = map[string]string{keyName: cfg.TSIGSecret}
+	}
+
+	nameserver := cfg.Nameserver
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	in, _, err := c.Exchange(m, nameserver)
+	if err != nil {
+		return err
+	}
+	if in != nil && in.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("unexpected rcode from %s: %s", nameserver, dns.RcodeToString[in.Rcode])
+	}
+	return nil
+}
+
+// loadRFC2136Config resolves settings from environment variables, then
+// applies any overrides found in the per-issuer Config JSON carried on the
+// ChallengeRequest.
+func loadRFC2136Config(ch *v1alpha1.ChallengeRequest) (*rfc2136Config, error) {
+	cfg := &rfc2136Config{
+		Nameserver:     os.Getenv("RFC2136_NAMESERVER"),
+		Zone:           os.Getenv("RFC2136_ZONE"),
+		TSIGKeyName:    os.Getenv("RFC2136_TSIG_KEY_NAME"),
+		TSIGAlgorithm:  os.Getenv("RFC2136_TSIG_ALGORITHM"),
+		TSIGSecret:     os.Getenv("RFC2136_TSIG_SECRET"),
+		TimeoutSeconds: 10,
+	}
+
+	if ch.Config != nil && len(ch.Config.Raw) > 0 {
+		var override rfc2136Config
+		if err := json.Unmarshal(ch.Config.Raw, &override); err != nil {
+			return nil, fmt.Errorf("rfc2136: error decoding solver config: %v", err)
+		}
+		if override.Nameserver != "" {
+			cfg.Nameserver = override.Nameserver
+		}
+		if override.Zone != "" {
+			cfg.Zone = override.Zone
+		}
+		if override.TSIGKeyName != "" {
+			cfg.TSIGKeyName = override.TSIGKeyName
+		}
+		if override.TSIGAlgorithm != "" {
+			cfg.TSIGAlgorithm = override.TSIGAlgorithm
+		}
+		if override.TSIGSecret != "" {
+			cfg.TSIGSecret = override.TSIGSecret
+		}
+		if override.TimeoutSeconds != 0 {
+			cfg.TimeoutSeconds = override.TimeoutSeconds
+		}
+	}
+
+	if cfg.Nameserver == "" {
+		return nil, fmt.Errorf("rfc2136: nameserver must be set via RFC2136_NAMESERVER or the issuer config")
+	}
+	if cfg.Zone == "" {
+		return nil, fmt.Errorf("rfc2136: zone must be set via RFC2136_ZONE or the issuer config")
+	}
+
+	return cfg, nil
+}